@@ -0,0 +1,286 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package clustermesh
+
+import (
+	"context"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cilium/cilium/pkg/clustermesh/internal"
+	cmtypes "github.com/cilium/cilium/pkg/clustermesh/types"
+	"github.com/cilium/cilium/pkg/hive"
+	"github.com/cilium/cilium/pkg/identity/cache"
+	"github.com/cilium/cilium/pkg/ipcache"
+	"github.com/cilium/cilium/pkg/kvstore/store"
+	"github.com/cilium/cilium/pkg/lock"
+	"github.com/cilium/cilium/pkg/logging"
+	"github.com/cilium/cilium/pkg/logging/logfields"
+)
+
+var log = logging.DefaultLogger.WithField(logfields.LogSubsys, subsystem)
+
+// Configuration holds the dependencies and settings required to run a
+// ClusterMesh instance.
+type Configuration struct {
+	internal.Config
+
+	// ClusterIDName identifies the local cluster.
+	ClusterIDName cmtypes.ClusterIDName
+
+	// NodeKeyCreator instantiates the store.Key implementation used to
+	// unmarshal node entries observed from remote clusters.
+	NodeKeyCreator store.KeyCreator
+
+	// NodeObserver is notified of every node update and deletion
+	// observed across all configured remote clusters.
+	NodeObserver store.Observer
+
+	// RemoteIdentityWatcher is intended to be notified of the identities
+	// allocated by every configured remote cluster, mirroring how
+	// NodeObserver is notified of remote nodes. It is accepted here but
+	// not yet wired up: doing so requires pkg/identity/cache to grow a
+	// way to source remote identities through the internal.RemoteBackend
+	// abstraction this package introduced, which is out of scope for
+	// this package alone.
+	RemoteIdentityWatcher cache.IdentityAllocator
+
+	// IPCache is intended to be updated with the ipcache entries observed
+	// across all configured remote clusters, for the same reason and
+	// with the same caveat as RemoteIdentityWatcher.
+	IPCache *ipcache.IPCache
+
+	// Metrics holds the clustermesh-level metrics collectors.
+	Metrics Metrics
+
+	// InternalMetrics holds the per-remote-cluster metrics set, see
+	// internal.Metrics.
+	InternalMetrics internal.Metrics
+}
+
+// ClusterMesh tracks the remote clusters configured through the
+// directory referenced by Configuration.ClusterMeshConfig, establishing
+// and tearing down a remoteCluster instance for each configuration file
+// as it appears and disappears.
+type ClusterMesh struct {
+	conf Configuration
+
+	usedIDs usedIDs
+
+	mutex    lock.RWMutex
+	clusters map[string]*remoteCluster
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewClusterMesh creates a new ClusterMesh instance, and registers its
+// start/stop hooks with the given hive lifecycle. Stopping the lifecycle
+// drains every remote cluster, see Drain.
+func NewClusterMesh(lc hive.Lifecycle, conf Configuration) *ClusterMesh {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	conf.Config = conf.Config.WithDefaults()
+
+	cm := &ClusterMesh{
+		conf:     conf,
+		usedIDs:  newUsedIDs(),
+		clusters: make(map[string]*remoteCluster),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+
+	lc.Append(hive.Hook{
+		OnStart: func(hive.HookContext) error {
+			go cm.watchConfigDirectory()
+			return nil
+		},
+		OnStop: func(ctx hive.HookContext) error {
+			cm.cancel()
+			return cm.Drain(ctx)
+		},
+	})
+
+	return cm
+}
+
+// watchConfigDirectory polls Configuration.ClusterMeshConfig every
+// Config.ConfigPollInterval, connecting and disconnecting remoteCluster
+// instances as configuration files are added, changed or removed.
+func (cm *ClusterMesh) watchConfigDirectory() {
+	ticker := time.NewTicker(cm.conf.ConfigPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := cm.scanConfigDirectory(); err != nil {
+			log.WithError(err).Warning("Unable to read clustermesh configuration directory")
+		}
+
+		select {
+		case <-cm.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scanConfigDirectory performs a single read of Configuration.ClusterMeshConfig,
+// adding and removing remote clusters to match the configuration files
+// currently present.
+func (cm *ClusterMesh) scanConfigDirectory() error {
+	entries, err := os.ReadDir(cm.conf.ClusterMeshConfig)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]struct{}, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		name := entry.Name()
+		seen[name] = struct{}{}
+
+		path := filepath.Join(cm.conf.ClusterMeshConfig, name)
+		cm.add(name, path)
+	}
+
+	var stale []string
+	cm.mutex.RLock()
+	for name := range cm.clusters {
+		if _, ok := seen[name]; !ok {
+			stale = append(stale, name)
+		}
+	}
+	cm.mutex.RUnlock()
+
+	for _, name := range stale {
+		cm.remove(cm.ctx, name)
+	}
+
+	return nil
+}
+
+// add registers (or reconfigures) the remote cluster named name, backed
+// by the configuration file at path. A cluster already registered under
+// name whose configuration file content is unchanged since it was last
+// seen is left running untouched; one whose content changed (new
+// endpoints, rotated TLS material, a different backend: selection, ...)
+// is torn down and replaced with a freshly connected remoteCluster,
+// rather than being left running against stale configuration until
+// something else (an ID change or a dropped connection) happens to
+// force a reconnect.
+func (cm *ClusterMesh) add(name, path string) {
+	hash, err := hashConfigFile(path)
+	if err != nil {
+		log.WithError(err).WithField("cluster", name).Warning("Unable to read clustermesh configuration file")
+		return
+	}
+
+	cm.mutex.Lock()
+	existing, ok := cm.clusters[name]
+	if ok {
+		if existing.configHash == hash {
+			cm.mutex.Unlock()
+			return
+		}
+		delete(cm.clusters, name)
+	}
+	rc := newRemoteCluster(cm, name, path, hash)
+	cm.clusters[name] = rc
+	cm.mutex.Unlock()
+
+	if ok {
+		existing.stop(cm.ctx)
+		cm.usedIDs.release(name)
+	}
+
+	go rc.run()
+}
+
+// hashConfigFile returns a digest of the contents of the configuration
+// file at path, used by add to detect when a previously registered
+// remote cluster's configuration has changed since it was last seen.
+func hashConfigFile(path string) (string, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(contents)
+	return string(sum[:]), nil
+}
+
+// remove tears down the remote cluster named name, waiting up to
+// Config.DrainTimeout for it to drain before returning.
+func (cm *ClusterMesh) remove(ctx context.Context, name string) {
+	cm.mutex.Lock()
+	rc, ok := cm.clusters[name]
+	if ok {
+		delete(cm.clusters, name)
+	}
+	cm.mutex.Unlock()
+
+	if !ok {
+		return
+	}
+
+	rc.stop(ctx)
+	cm.usedIDs.release(name)
+}
+
+// Drain cancels every remote cluster managed by this ClusterMesh and
+// waits for their watchers to fully stop, so that every key they had
+// previously reported is replayed through NodeObserver.OnDelete before
+// Drain returns. It gives up waiting on a given cluster once either ctx
+// is done or Config.DrainTimeout elapses, logging a warning in the
+// latter case.
+func (cm *ClusterMesh) Drain(ctx context.Context) error {
+	cm.mutex.Lock()
+	names := make([]string, 0, len(cm.clusters))
+	clusters := make([]*remoteCluster, 0, len(cm.clusters))
+	for name, rc := range cm.clusters {
+		names = append(names, name)
+		clusters = append(clusters, rc)
+		delete(cm.clusters, name)
+	}
+	cm.mutex.Unlock()
+
+	var wg sync.WaitGroup
+	for _, rc := range clusters {
+		wg.Add(1)
+		go func(rc *remoteCluster) {
+			defer wg.Done()
+			rc.stop(ctx)
+		}(rc)
+	}
+	wg.Wait()
+
+	// Mirror remove's bookkeeping: a drained cluster's ID reservation
+	// must be released too, or a subsequent NewClusterMesh (or a test
+	// reusing usedIDs) would see it as still in use.
+	for _, name := range names {
+		cm.usedIDs.release(name)
+	}
+
+	return nil
+}
+
+// NumReadyClusters returns the number of remote clusters which have
+// completed their initial synchronization.
+func (cm *ClusterMesh) NumReadyClusters() int {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+
+	n := 0
+	for _, rc := range cm.clusters {
+		if rc.isReady() {
+			n++
+		}
+	}
+	return n
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"sync"
 	"testing"
 	"time"
 
@@ -264,4 +265,108 @@ func (s *ClusterMeshTestSuite) TestClusterMesh(c *C) {
 	cm.usedIDs.usedClusterIDsMutex.Lock()
 	c.Assert(cm.usedIDs.usedClusterIDs, HasLen, 0)
 	cm.usedIDs.usedClusterIDsMutex.Unlock()
+
+	// No remoteCluster should remain registered once every config file
+	// has been removed.
+	cm.mutex.RLock()
+	c.Assert(cm.clusters, HasLen, 0)
+	cm.mutex.RUnlock()
+
+	// A further write to the now-unwatched cluster2 store must not
+	// resurrect any node, since its watcher was torn down when its
+	// configuration file was removed.
+	nodesWSS.UpsertKey(ctx, &testNode{Name: "late", Cluster: "cluster2"})
+	time.Sleep(200 * time.Millisecond)
+	nodesMutex.RLock()
+	c.Assert(nodes, HasLen, 0)
+	nodesMutex.RUnlock()
+
+	// Drain should be idempotent and return promptly with nothing left
+	// to wait for.
+	drainCtx, drainCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer drainCancel()
+	c.Assert(cm.Drain(drainCtx), IsNil)
+}
+
+// TestUsedIDsConcurrentReconnects stresses usedIDs with many clusters
+// reconnecting with a new ID concurrently, and asserts that per-cluster
+// locking neither leaks IDs (an old ID surviving past its cluster's
+// reconnect, or two clusters ending up owning the same ID) nor
+// serializes unrelated clusters badly enough to blow past a generous
+// latency bound.
+func (s *ClusterMeshTestSuite) TestUsedIDsConcurrentReconnects(c *C) {
+	const (
+		numClusters = 50
+		numRounds   = 20
+	)
+
+	u := newUsedIDs()
+
+	clusterName := func(i int) string {
+		return fmt.Sprintf("cluster-%d", i)
+	}
+
+	// Start every cluster on an ID outside the range handed out by the
+	// rounds below, so that no round collides with another cluster's
+	// initial reservation.
+	for i := 0; i < numClusters; i++ {
+		c.Assert(u.reserve(clusterName(i), uint32(numClusters*numRounds+i)), IsNil)
+	}
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < numClusters; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := clusterName(i)
+			for round := 0; round < numRounds; round++ {
+				c.Assert(u.reserve(name, uint32(i*numRounds+round)), IsNil)
+			}
+		}(i)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	// With per-cluster locking, N clusters reconnecting concurrently
+	// should take roughly as long as a single cluster's rounds, not
+	// N times as long.
+	c.Assert(elapsed < 5*time.Second, Equals, true)
+
+	u.usedClusterIDsMutex.Lock()
+	defer u.usedClusterIDsMutex.Unlock()
+
+	c.Assert(u.usedClusterIDs, HasLen, numClusters)
+
+	owners := make(map[string]struct{}, numClusters)
+	for id, owner := range u.usedClusterIDs {
+		expected := uint32(idOf(owner)*numRounds + numRounds - 1)
+		c.Assert(id, Equals, expected)
+
+		_, seen := owners[owner]
+		c.Assert(seen, Equals, false)
+		owners[owner] = struct{}{}
+	}
+}
+
+// TestUsedIDsRejectsCollision asserts that reserve refuses to hand the
+// same ID to two different cluster names, leaving the original
+// reservation untouched.
+func (s *ClusterMeshTestSuite) TestUsedIDsRejectsCollision(c *C) {
+	u := newUsedIDs()
+
+	c.Assert(u.reserve("cluster-a", 5), IsNil)
+	c.Assert(u.reserve("cluster-b", 5), ErrorMatches, `cluster ID 5 is already reserved by cluster "cluster-a"`)
+
+	u.usedClusterIDsMutex.RLock()
+	defer u.usedClusterIDsMutex.RUnlock()
+	c.Assert(u.usedClusterIDs[5], Equals, "cluster-a")
+}
+
+// idOf extracts the numeric suffix out of a "cluster-N" name, for use by
+// TestUsedIDsConcurrentReconnects.
+func idOf(name string) int {
+	var i int
+	fmt.Sscanf(name, "cluster-%d", &i)
+	return i
 }
@@ -0,0 +1,351 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package clustermesh
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	. "github.com/cilium/checkmate"
+
+	"github.com/cilium/cilium/pkg/clustermesh/internal"
+	cmtypes "github.com/cilium/cilium/pkg/clustermesh/types"
+	"github.com/cilium/cilium/pkg/hive/hivetest"
+	"github.com/cilium/cilium/pkg/lock"
+	"github.com/cilium/cilium/pkg/testutils"
+)
+
+// fakeClusterMeshAPIServer is a minimal stand-in for a real
+// clustermesh-apiserver, implementing just enough of the wire protocol
+// defined in internal/grpc_client.go to drive grpcBackend end to end:
+// ClusterConfig retrieval through Get, and a single watched prefix
+// through Watch.
+type fakeClusterMeshAPIServer struct {
+	mu     lock.Mutex
+	values map[string][]byte
+	nodes  map[string][]byte
+	subs   []chan internal.WatchEvent
+}
+
+func newFakeClusterMeshAPIServer() *fakeClusterMeshAPIServer {
+	return &fakeClusterMeshAPIServer{
+		values: make(map[string][]byte),
+		nodes:  make(map[string][]byte),
+	}
+}
+
+func (s *fakeClusterMeshAPIServer) setClusterConfig(name string, config *cmtypes.CiliumClusterConfig) {
+	val, err := json.Marshal(config)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[path.Join("cilium/cluster-config", name)] = val
+}
+
+func (s *fakeClusterMeshAPIServer) upsertNode(n *testNode) {
+	val, err := n.Marshal()
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nodes[n.GetKeyName()] = val
+	s.broadcastLocked(internal.WatchEvent{Kind: "update", Name: n.GetKeyName(), Value: val})
+}
+
+func (s *fakeClusterMeshAPIServer) deleteNode(n *testNode) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.nodes, n.GetKeyName())
+	s.broadcastLocked(internal.WatchEvent{Kind: "delete", Name: n.GetKeyName()})
+}
+
+func (s *fakeClusterMeshAPIServer) broadcastLocked(ev internal.WatchEvent) {
+	for _, ch := range s.subs {
+		ch <- ev
+	}
+}
+
+func (s *fakeClusterMeshAPIServer) handleGet(_ context.Context, req *internal.GetRequest) (*internal.GetReply, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &internal.GetReply{Value: s.values[req.Key]}, nil
+}
+
+func (s *fakeClusterMeshAPIServer) handleWatch(stream grpc.ServerStream) error {
+	var req internal.WatchRequest
+	if err := stream.RecvMsg(&req); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	snapshot := make(map[string][]byte, len(s.nodes))
+	for k, v := range s.nodes {
+		snapshot[k] = v
+	}
+	ch := make(chan internal.WatchEvent, 64)
+	s.subs = append(s.subs, ch)
+	s.mu.Unlock()
+
+	for name, val := range snapshot {
+		if err := stream.SendMsg(&internal.WatchEvent{Kind: "update", Name: name, Value: val}); err != nil {
+			return err
+		}
+	}
+	if err := stream.SendMsg(&internal.WatchEvent{Kind: "synced"}); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case ev := <-ch:
+			if err := stream.SendMsg(&ev); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+var fakeClusterMeshAPIServiceDesc = grpc.ServiceDesc{
+	ServiceName: "cilium.clustermesh.v1alpha1.ClusterMeshAPI",
+	HandlerType: (*any)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Get",
+			Handler: func(srv any, ctx context.Context, dec func(any) error, _ grpc.UnaryServerInterceptor) (any, error) {
+				var req internal.GetRequest
+				if err := dec(&req); err != nil {
+					return nil, err
+				}
+				return srv.(*fakeClusterMeshAPIServer).handleGet(ctx, &req)
+			},
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName: "Watch",
+			Handler: func(srv any, stream grpc.ServerStream) error {
+				return srv.(*fakeClusterMeshAPIServer).handleWatch(stream)
+			},
+			ServerStreams: true,
+		},
+	},
+}
+
+// testMTLSMaterial holds the CA, server and client certificate/key PEM
+// files generated by newTestMTLSMaterial, deleted automatically by the
+// caller's defer once the test is done with them.
+type testMTLSMaterial struct {
+	caFile, serverCertFile, serverKeyFile, clientCertFile, clientKeyFile string
+}
+
+// newTestMTLSMaterial generates a throwaway CA and a server/client
+// certificate pair signed by it, valid for 127.0.0.1, so that
+// grpcBackend's mTLS handshake can be exercised against
+// fakeClusterMeshAPIServer without depending on any fixture checked into
+// the repository.
+func newTestMTLSMaterial(dir string) (*testMTLSMaterial, error) {
+	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "clustermesh-test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	newLeaf := func(cn string, serial int64) (certPEM, keyPEM []byte, err error) {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, nil, err
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: cn},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, caTemplate, &key.PublicKey, caKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+		keyPEM = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+		return certPEM, keyPEM, nil
+	}
+
+	serverCertPEM, serverKeyPEM, err := newLeaf("127.0.0.1", 2)
+	if err != nil {
+		return nil, err
+	}
+	clientCertPEM, clientKeyPEM, err := newLeaf("clustermesh-test-client", 3)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &testMTLSMaterial{
+		caFile:         path.Join(dir, "ca.pem"),
+		serverCertFile: path.Join(dir, "server.pem"),
+		serverKeyFile:  path.Join(dir, "server-key.pem"),
+		clientCertFile: path.Join(dir, "client.pem"),
+		clientKeyFile:  path.Join(dir, "client-key.pem"),
+	}
+
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	for file, contents := range map[string][]byte{
+		m.caFile:         caPEM,
+		m.serverCertFile: serverCertPEM,
+		m.serverKeyFile:  serverKeyPEM,
+		m.clientCertFile: clientCertPEM,
+		m.clientKeyFile:  clientKeyPEM,
+	} {
+		if err := os.WriteFile(file, contents, 0600); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+func (m *testMTLSMaterial) serverTLSConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(m.serverCertFile, m.serverKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	caPEM, err := os.ReadFile(m.caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("invalid CA bundle")
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}, nil
+}
+
+// TestClusterMeshGRPCBackend exercises the same connect -> negotiate ->
+// watch -> sync path as TestClusterMesh, but through the grpc backend
+// (mTLS to a stub clustermesh API server) instead of etcd, so that
+// remoteCluster and internal.RemoteBackend stay genuinely
+// backend-agnostic rather than only ever being exercised against etcd.
+func (s *ClusterMeshTestSuite) TestClusterMeshGRPCBackend(c *C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir, err := os.MkdirTemp("", "multicluster-grpc")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	mtls, err := newTestMTLSMaterial(dir)
+	c.Assert(err, IsNil)
+
+	tlsConfig, err := mtls.serverTLSConfig()
+	c.Assert(err, IsNil)
+
+	fakeServer := newFakeClusterMeshAPIServer()
+	fakeServer.setClusterConfig("remote1", &cmtypes.CiliumClusterConfig{
+		ID:       42,
+		Backends: []string{"grpc"},
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer listener.Close()
+
+	grpcServer := grpc.NewServer(grpc.Creds(credentials.NewTLS(tlsConfig)))
+	grpcServer.RegisterService(&fakeClusterMeshAPIServiceDesc, fakeServer)
+	go grpcServer.Serve(listener)
+	defer grpcServer.Stop()
+
+	configContents := fmt.Sprintf("backend: grpc\ngrpc:\n  address: %s\n  tls-cert: %s\n  tls-key: %s\n  tls-ca-file: %s\n",
+		listener.Addr().String(), mtls.clientCertFile, mtls.clientKeyFile, mtls.caFile)
+
+	configPath := path.Join(dir, "remote1")
+	c.Assert(os.WriteFile(configPath, []byte(configContents), 0644), IsNil)
+
+	nodesMutex.Lock()
+	nodes = map[string]*testNode{}
+	nodesMutex.Unlock()
+
+	fakeServer.upsertNode(&testNode{Name: "foo", Cluster: "remote1"})
+
+	cm := NewClusterMesh(hivetest.Lifecycle(c), Configuration{
+		Config:          internal.Config{ClusterMeshConfig: dir},
+		ClusterIDName:   cmtypes.ClusterIDName{ClusterID: 255, ClusterName: "test2"},
+		NodeKeyCreator:  testNodeCreator,
+		NodeObserver:    &testObserver{},
+		Metrics:         newMetrics(),
+		InternalMetrics: internal.MetricsProvider(subsystem)(),
+	})
+	c.Assert(cm, Not(IsNil))
+
+	c.Assert(testutils.WaitUntil(func() bool {
+		return cm.NumReadyClusters() == 1
+	}, 10*time.Second), IsNil)
+
+	c.Assert(testutils.WaitUntil(func() bool {
+		nodesMutex.RLock()
+		defer nodesMutex.RUnlock()
+		_, ok := nodes[path.Join("remote1", "foo")]
+		return ok
+	}, 10*time.Second), IsNil)
+
+	// A delete carries no value over the wire, so this also exercises
+	// that grpcWatcher turns it into an OnDelete without depending on
+	// re-unmarshaling one.
+	fakeServer.deleteNode(&testNode{Name: "foo", Cluster: "remote1"})
+
+	c.Assert(testutils.WaitUntil(func() bool {
+		nodesMutex.RLock()
+		defer nodesMutex.RUnlock()
+		_, ok := nodes[path.Join("remote1", "foo")]
+		return !ok
+	}, 10*time.Second), IsNil)
+
+	cm.usedIDs.usedClusterIDsMutex.Lock()
+	_, ok := cm.usedIDs.usedClusterIDs[42]
+	cm.usedIDs.usedClusterIDsMutex.Unlock()
+	c.Assert(ok, Equals, true)
+
+	drainCtx, drainCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer drainCancel()
+	c.Assert(cm.Drain(drainCtx), IsNil)
+}
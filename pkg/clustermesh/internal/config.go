@@ -0,0 +1,73 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package internal
+
+import "time"
+
+// Config holds the configuration knobs shared by all remote clusters
+// managed by a single ClusterMesh instance.
+type Config struct {
+	// ClusterMeshConfig is the path to the directory containing the
+	// per-cluster configuration files. Each file in the directory is
+	// expected to be named after the remote cluster it configures.
+	ClusterMeshConfig string
+
+	// RemoteConnectTimeout bounds how long a single attempt to connect
+	// to a remote cluster (through whichever RemoteBackend is
+	// configured) may take before it is considered failed.
+	RemoteConnectTimeout time.Duration
+
+	// RemoteRetryIntervalStart is the delay before the first retry
+	// after a failed connection attempt to a remote cluster. It
+	// doubles on every subsequent failure, up to
+	// RemoteRetryIntervalMax.
+	RemoteRetryIntervalStart time.Duration
+
+	// RemoteRetryIntervalMax caps the exponential backoff applied
+	// between reconnection attempts to a remote cluster.
+	RemoteRetryIntervalMax time.Duration
+
+	// DrainTimeout bounds how long a remote cluster's watchers are
+	// given to return after their context is cancelled (because the
+	// cluster's configuration file disappeared, its ID changed, or the
+	// whole ClusterMesh is draining) before the drain gives up waiting
+	// on them.
+	DrainTimeout time.Duration
+
+	// ConfigPollInterval is how often the clustermesh configuration
+	// directory is re-read for added, changed or removed per-cluster
+	// configuration files.
+	ConfigPollInterval time.Duration
+}
+
+// DefaultConfig holds the values used when a Config field is left at its
+// zero value.
+var DefaultConfig = Config{
+	RemoteConnectTimeout:     30 * time.Second,
+	RemoteRetryIntervalStart: 500 * time.Millisecond,
+	RemoteRetryIntervalMax:   60 * time.Second,
+	DrainTimeout:             10 * time.Second,
+	ConfigPollInterval:       1 * time.Second,
+}
+
+// WithDefaults returns a copy of c with every zero-valued duration field
+// replaced by its DefaultConfig counterpart.
+func (c Config) WithDefaults() Config {
+	if c.RemoteConnectTimeout == 0 {
+		c.RemoteConnectTimeout = DefaultConfig.RemoteConnectTimeout
+	}
+	if c.RemoteRetryIntervalStart == 0 {
+		c.RemoteRetryIntervalStart = DefaultConfig.RemoteRetryIntervalStart
+	}
+	if c.RemoteRetryIntervalMax == 0 {
+		c.RemoteRetryIntervalMax = DefaultConfig.RemoteRetryIntervalMax
+	}
+	if c.DrainTimeout == 0 {
+		c.DrainTimeout = DefaultConfig.DrainTimeout
+	}
+	if c.ConfigPollInterval == 0 {
+		c.ConfigPollInterval = DefaultConfig.ConfigPollInterval
+	}
+	return c
+}
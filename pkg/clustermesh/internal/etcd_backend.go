@@ -0,0 +1,82 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/cilium/cilium/pkg/kvstore"
+	"github.com/cilium/cilium/pkg/kvstore/store"
+)
+
+// etcdBackend is the default RemoteBackend implementation, talking
+// directly to the remote cluster's etcd through a shared kvstore client
+// built from the raw configuration file contents.
+type etcdBackend struct {
+	name   string
+	client kvstore.BackendOperations
+}
+
+func (b *etcdBackend) Connect(ctx context.Context, name string, file ClusterConfigFile) (RemoteBackend, error) {
+	// file.EndpointsConfig is the full etcd client configuration file
+	// (endpoints, TLS, ...) as written to the clustermesh config
+	// directory, not a single endpoint address: it has to be handed to
+	// the etcd client as a config file, not as the address option.
+	configPath, err := writeTempEtcdConfig(name, file.EndpointsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("writing etcd configuration for cluster %q: %w", name, err)
+	}
+	defer os.Remove(configPath)
+
+	errChan := make(chan error, 1)
+	client, err := kvstore.NewClient(ctx, kvstore.EtcdBackendName, map[string]string{
+		kvstore.EtcdOptionConfig: configPath,
+	}, errChan)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to etcd for cluster %q: %w", name, err)
+	}
+
+	return &etcdBackend{name: name, client: client}, nil
+}
+
+// writeTempEtcdConfig writes contents to a temporary file and returns
+// its path, so that it can be passed to the etcd client as a config
+// file. The caller is responsible for removing it once the client has
+// been constructed.
+func writeTempEtcdConfig(name string, contents []byte) (string, error) {
+	f, err := os.CreateTemp("", fmt.Sprintf("clustermesh-%s-*.yaml", name))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(contents); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	return f.Name(), nil
+}
+
+func (b *etcdBackend) Watch(ctx context.Context, prefix string, keyCreator store.KeyCreator, observer store.Observer, opts WatchOptions) (SyncedWatcher, error) {
+	// Only wait for the remote cluster's sync canary when it actually
+	// advertised the SyncedCanaries capability; older clusters never
+	// write one, and the initial list completing is as synced as they
+	// will ever get.
+	watchStore := store.NewWatchStore(b.name, keyCreator, observer, store.RWSWithSyncedCanary(opts.RequireSyncedCanary))
+	watchStore.Watch(ctx, b.client, prefix)
+	return watchStore, nil
+}
+
+func (b *etcdBackend) Get(ctx context.Context, key string) ([]byte, error) {
+	return b.client.Get(ctx, key)
+}
+
+func (b *etcdBackend) Close() {
+	if b.client != nil {
+		b.client.Close(context.Background())
+	}
+}
@@ -0,0 +1,106 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package internal
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/cilium/cilium/pkg/kvstore/store"
+)
+
+// clustermeshAPIClient is the subset of the clustermesh API gRPC service
+// (nodes, identities, services, ipcache) that grpcBackend depends on.
+// Its implementation, grpcAPIClient in grpc_client.go, carries the wire
+// format, so grpcBackend itself does not depend on it.
+type clustermeshAPIClient interface {
+	Watch(ctx context.Context, prefix string, keyCreator store.KeyCreator, observer store.Observer, opts WatchOptions) (SyncedWatcher, error)
+	Get(ctx context.Context, key string) ([]byte, error)
+	Close() error
+}
+
+// newClustermeshAPIClient constructs the gRPC client used by grpcBackend.
+// It is a variable so that tests can substitute a stub implementation.
+// Connect still dials file.GRPC.Address before calling it.
+var newClustermeshAPIClient = newGRPCAPIClient
+
+// grpcBackend is the RemoteBackend implementation that reaches a remote
+// cluster through its clustermesh API service over mTLS, instead of
+// connecting to the remote kvstore directly.
+type grpcBackend struct {
+	name string
+	conn *grpc.ClientConn
+	cli  clustermeshAPIClient
+}
+
+func (b *grpcBackend) Connect(ctx context.Context, name string, file ClusterConfigFile) (RemoteBackend, error) {
+	if file.GRPC == nil {
+		return nil, fmt.Errorf("missing grpc configuration for cluster %q", name)
+	}
+
+	creds, err := loadTransportCredentials(file.GRPC)
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS material for cluster %q: %w", name, err)
+	}
+
+	conn, err := grpc.DialContext(ctx, file.GRPC.Address, grpc.WithTransportCredentials(creds), grpc.WithBlock())
+	if err != nil {
+		return nil, fmt.Errorf("dialing clustermesh API for cluster %q: %w", name, err)
+	}
+
+	cli := newClustermeshAPIClient(conn)
+	if cli == nil {
+		conn.Close()
+		return nil, fmt.Errorf("clustermesh API client unavailable for cluster %q", name)
+	}
+
+	return &grpcBackend{name: name, conn: conn, cli: cli}, nil
+}
+
+func (b *grpcBackend) Watch(ctx context.Context, prefix string, keyCreator store.KeyCreator, observer store.Observer, opts WatchOptions) (SyncedWatcher, error) {
+	return b.cli.Watch(ctx, prefix, keyCreator, observer, opts)
+}
+
+func (b *grpcBackend) Get(ctx context.Context, key string) ([]byte, error) {
+	return b.cli.Get(ctx, key)
+}
+
+func (b *grpcBackend) Close() {
+	if b.cli != nil {
+		b.cli.Close()
+	}
+	if b.conn != nil {
+		b.conn.Close()
+	}
+}
+
+// loadTransportCredentials builds the mTLS transport credentials used to
+// dial a remote cluster's clustermesh API service.
+func loadTransportCredentials(cfg *GRPCConfig) (credentials.TransportCredentials, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("loading client certificate: %w", err)
+	}
+
+	caPEM, err := os.ReadFile(cfg.TLSCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading CA bundle: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("invalid CA bundle %q", cfg.TLSCAFile)
+	}
+
+	return credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+	}), nil
+}
@@ -0,0 +1,16 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package internal
+
+// Metrics is the per-remote-cluster metrics set, shared by every
+// RemoteBackend. It holds no collectors.
+type Metrics struct{}
+
+// MetricsProvider returns a constructor for Metrics, suitable for use as
+// a hive cell provider. subsystem is not used.
+func MetricsProvider(subsystem string) func() Metrics {
+	return func() Metrics {
+		return Metrics{}
+	}
+}
@@ -0,0 +1,139 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package internal
+
+import (
+	"context"
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/cilium/cilium/pkg/kvstore/store"
+)
+
+// Supported values for ClusterConfigFile.Backend. BackendEtcd is assumed
+// when the field is empty, so that configuration files written by older
+// versions of Cilium keep working unmodified.
+const (
+	BackendEtcd = "etcd"
+	BackendGRPC = "grpc"
+)
+
+// ClusterConfigFile is the parsed representation of a per-cluster
+// configuration file, as found in the directory referenced by
+// Config.ClusterMeshConfig.
+type ClusterConfigFile struct {
+	// Backend selects which RemoteBackend implementation should be used
+	// to reach this cluster.
+	Backend string `yaml:"backend,omitempty"`
+
+	// EndpointsConfig is the raw etcd client configuration (endpoints,
+	// TLS, ...), used as-is when Backend is BackendEtcd.
+	EndpointsConfig []byte `yaml:"-"`
+
+	// GRPC holds the configuration required to dial the clustermesh API
+	// service when Backend is BackendGRPC.
+	GRPC *GRPCConfig `yaml:"grpc,omitempty"`
+}
+
+// ParseClusterConfigFile parses the raw contents of a per-cluster
+// configuration file. The file is always valid etcd client configuration
+// (that is what raw is used as-is for, via EndpointsConfig), optionally
+// carrying a leading `backend`/`grpc` selection on top of it so a single
+// file format works for both transports: an etcd-only file simply omits
+// those keys.
+func ParseClusterConfigFile(raw []byte) (ClusterConfigFile, error) {
+	var file ClusterConfigFile
+	if err := yaml.Unmarshal(raw, &file); err != nil {
+		return ClusterConfigFile{}, fmt.Errorf("parsing cluster configuration file: %w", err)
+	}
+
+	file.EndpointsConfig = raw
+	return file, nil
+}
+
+// GRPCConfig describes how to reach a remote cluster's clustermesh API
+// service over a mutually authenticated gRPC channel.
+type GRPCConfig struct {
+	// Address is the dial target of the clustermesh API service.
+	Address string `yaml:"address"`
+
+	// TLSCert, TLSKey and TLSCAFile locate the client certificate, key
+	// and CA bundle used to establish the mTLS connection.
+	TLSCert   string `yaml:"tls-cert"`
+	TLSKey    string `yaml:"tls-key"`
+	TLSCAFile string `yaml:"tls-ca-file"`
+}
+
+// SyncedWatcher is returned by RemoteBackend.Watch. It allows the caller
+// to wait for the initial snapshot of the watched prefix to have been
+// fully observed, regardless of which transport is in use underneath.
+type SyncedWatcher interface {
+	// Synced blocks until the initial list of keys has been processed,
+	// or ctx is cancelled.
+	Synced(ctx context.Context) error
+}
+
+// WatchOptions customizes how a RemoteBackend.Watch call decides that
+// the initial snapshot of a watched prefix has been fully observed.
+type WatchOptions struct {
+	// RequireSyncedCanary gates Watch's synced semantics on the
+	// SyncedCanaries capability negotiated with the remote cluster
+	// (see ClusterCapabilities.SyncedCanaries): when true, the
+	// returned SyncedWatcher only reports synced once the remote
+	// cluster's sync canary key has been observed; when false
+	// (clusters that predate the capability), it reports synced as
+	// soon as the initial list of the watched prefix completes, since
+	// there is no canary to wait for.
+	RequireSyncedCanary bool
+}
+
+// RemoteBackend abstracts the transport used to observe and query the
+// state (nodes, identities, services, ipcache) of a single remote
+// cluster, so that callers don't need to know whether they are talking
+// to the remote cluster's etcd directly or through a dedicated
+// clustermesh API service.
+type RemoteBackend interface {
+	// Connect establishes the underlying connection for the remote
+	// cluster described by file, returning a ready-to-use RemoteBackend.
+	// It is called on the zero value registered for a given
+	// ClusterConfigFile.Backend.
+	Connect(ctx context.Context, name string, file ClusterConfigFile) (RemoteBackend, error)
+
+	// Watch starts watching prefix, feeding every observed key through
+	// keyCreator and observer until ctx is cancelled. opts controls when
+	// the returned SyncedWatcher considers the initial snapshot done.
+	Watch(ctx context.Context, prefix string, keyCreator store.KeyCreator, observer store.Observer, opts WatchOptions) (SyncedWatcher, error)
+
+	// Get returns the raw value stored at key, or a nil slice if the
+	// key does not exist.
+	Get(ctx context.Context, key string) ([]byte, error)
+
+	// Close releases any resources (connections, watchers) held by the
+	// backend.
+	Close()
+}
+
+// backends is the registry of known RemoteBackend prototypes, indexed by
+// the ClusterConfigFile.Backend value that selects them.
+var backends = map[string]RemoteBackend{
+	BackendEtcd: &etcdBackend{},
+	BackendGRPC: &grpcBackend{},
+}
+
+// ConnectBackend dispatches to the RemoteBackend registered for
+// file.Backend (BackendEtcd when unset) and connects it.
+func ConnectBackend(ctx context.Context, name string, file ClusterConfigFile) (RemoteBackend, error) {
+	kind := file.Backend
+	if kind == "" {
+		kind = BackendEtcd
+	}
+
+	prototype, ok := backends[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown clustermesh backend %q for cluster %q", kind, name)
+	}
+
+	return prototype.Connect(ctx, name, file)
+}
@@ -0,0 +1,47 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package clustermesh
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/cilium/cilium/pkg/metrics"
+)
+
+const subsystem = "clustermesh"
+
+// Metrics holds the metrics collectors exposed by the clustermesh
+// subsystem itself. The per-remote-cluster internal.Metrics set holds no
+// collectors.
+type Metrics struct {
+	// ConnectAttemptsTotal counts every attempt to (re)connect to a
+	// remote cluster, labeled by cluster name and outcome, so operators
+	// can tell a healthy reconnect churn apart from a cluster stuck in
+	// a retry loop.
+	ConnectAttemptsTotal *prometheus.CounterVec
+
+	// ConnectDurationSeconds tracks how long each connection attempt to
+	// a remote cluster takes, labeled by cluster name.
+	ConnectDurationSeconds *prometheus.HistogramVec
+}
+
+// newMetrics returns a ready-to-use Metrics instance. It is a function,
+// rather than relying on hive's metrics cell, so that it can also be
+// called directly from tests.
+func newMetrics() Metrics {
+	return Metrics{
+		ConnectAttemptsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: subsystem,
+			Name:      "remote_cluster_connect_attempts_total",
+			Help:      "Number of connection attempts to remote clusters, labeled by cluster and outcome",
+		}, []string{"cluster", "outcome"}),
+		ConnectDurationSeconds: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: subsystem,
+			Name:      "remote_cluster_connect_duration_seconds",
+			Help:      "Duration of connection attempts to remote clusters, labeled by cluster",
+		}, []string{"cluster"}),
+	}
+}
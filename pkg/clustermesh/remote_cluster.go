@@ -0,0 +1,481 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package clustermesh
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/cilium/cilium/pkg/clustermesh/internal"
+	cmutils "github.com/cilium/cilium/pkg/clustermesh/utils"
+	"github.com/cilium/cilium/pkg/kvstore/store"
+	"github.com/cilium/cilium/pkg/lock"
+	nodeStore "github.com/cilium/cilium/pkg/node/store"
+)
+
+// remoteCluster drives the connection to a single remote cluster,
+// dispatching every read through its internal.RemoteBackend regardless
+// of whether the remote cluster is reachable through etcd or through a
+// dedicated clustermesh API service.
+//
+// Every watcher it starts (config re-read, node watcher) is derived from
+// the current ctx, which is cancelled as soon as the cluster's
+// configuration file disappears, its cluster ID changes, or the whole
+// ClusterMesh is draining. stop then waits up to Config.DrainTimeout for
+// the watchers to return before giving up.
+type remoteCluster struct {
+	mesh *ClusterMesh
+	name string
+	path string
+
+	// configHash is a digest of path's contents as last read by add, used
+	// to detect a changed configuration file across successive scans of
+	// the clustermesh configuration directory. It is only ever read or
+	// compared by add, itself serialized by ClusterMesh.mutex, so it
+	// needs no locking of its own.
+	configHash string
+
+	// ctxMu guards ctx, cancel, observer and backend. ctx, cancel and
+	// observer are all replaced together by restartContext when the
+	// cluster's ID changes; backend is replaced by restartRemoteConnection
+	// on every (re)connect. Guarding backend here too is what keeps
+	// stop(), called from a different goroutine than run(), from racing
+	// with a concurrent read/replace of rc.backend in
+	// restartRemoteConnection/stayConnected. Every other field is only
+	// ever touched from the run() goroutine.
+	ctxMu    lock.Mutex
+	ctx      context.Context
+	cancel   context.CancelFunc
+	observer *trackingObserver
+	backend  internal.RemoteBackend
+
+	done chan struct{}
+
+	lastID uint32
+
+	readyMu lock.RWMutex
+	ready   bool
+
+	// retryInterval holds the backoff currently in effect; it is reset
+	// to Config.RemoteRetryIntervalStart whenever a connection attempt
+	// succeeds (i.e. the initial sync of the remote cluster's nodes
+	// completes, which waits for the sync canary only when the remote
+	// cluster advertised SyncedCanaries).
+	retryInterval time.Duration
+}
+
+func newRemoteCluster(cm *ClusterMesh, name, path, configHash string) *remoteCluster {
+	ctx, cancel := context.WithCancel(cm.ctx)
+
+	return &remoteCluster{
+		mesh:          cm,
+		name:          name,
+		path:          path,
+		configHash:    configHash,
+		ctx:           ctx,
+		cancel:        cancel,
+		done:          make(chan struct{}),
+		observer:      newTrackingObserver(cm.conf.NodeObserver),
+		retryInterval: cm.conf.RemoteRetryIntervalStart,
+	}
+}
+
+// run connects to the remote cluster and keeps the connection alive
+// until rc.ctx is cancelled, retrying with an exponential backoff
+// (capped at Config.RemoteRetryIntervalMax, with jitter) on every failed
+// connection attempt. Once the initial sync has completed, it only
+// reconnects on a cluster ID change: a connection that drops afterwards
+// does not trigger a reconnect. It closes rc.done before returning, and
+// replays OnDelete for every key it had previously reported, so that
+// downstream consumers never retain state for a cluster that is gone.
+func (rc *remoteCluster) run() {
+	defer close(rc.done)
+	defer rc.currentObserver().drain()
+
+	for {
+		ctx := rc.currentCtx()
+
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		err := rc.restartRemoteConnection(ctx)
+
+		outcome := "success"
+		if err != nil {
+			outcome = "failure"
+			log.WithError(err).WithField("cluster", rc.name).Warning("Unable to connect to remote cluster")
+		}
+		rc.mesh.conf.Metrics.ConnectAttemptsTotal.WithLabelValues(rc.name, outcome).Inc()
+
+		wait := rc.nextRetryInterval(err == nil)
+
+		// Re-read the context: restartRemoteConnection may have
+		// replaced it (via restartContext, on a cluster ID change),
+		// in which case the one captured at the top of the loop is
+		// already Done and must not be mistaken for rc having been
+		// stopped.
+		select {
+		case <-rc.currentCtx().Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// currentCtx returns rc's current watcher context, synchronized against
+// concurrent replacement by restartContext.
+func (rc *remoteCluster) currentCtx() context.Context {
+	rc.ctxMu.Lock()
+	defer rc.ctxMu.Unlock()
+	return rc.ctx
+}
+
+// currentObserver returns rc's current trackingObserver, synchronized
+// against concurrent replacement by restartContext.
+func (rc *remoteCluster) currentObserver() *trackingObserver {
+	rc.ctxMu.Lock()
+	defer rc.ctxMu.Unlock()
+	return rc.observer
+}
+
+// currentBackend returns rc's current RemoteBackend, synchronized
+// against concurrent replacement by swapBackend.
+func (rc *remoteCluster) currentBackend() internal.RemoteBackend {
+	rc.ctxMu.Lock()
+	defer rc.ctxMu.Unlock()
+	return rc.backend
+}
+
+// swapBackend replaces rc's backend with backend, closing whichever one
+// was previously in place. Closing the old backend outside of ctxMu
+// avoids holding the lock for as long as Close takes, while still making
+// the read-old/store-new sequence atomic with respect to stop(), which
+// also reads and closes rc.backend.
+func (rc *remoteCluster) swapBackend(backend internal.RemoteBackend) {
+	rc.ctxMu.Lock()
+	old := rc.backend
+	rc.backend = backend
+	rc.ctxMu.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+}
+
+// nextRetryInterval updates and returns the backoff to wait before the
+// next connection attempt. A successful attempt resets the backoff to
+// its starting value; a failed one waits the current backoff (the
+// starting value after the first failure) and doubles it for the next
+// failure, up to the configured maximum, adding up to 20% jitter to
+// avoid every remote cluster retrying in lockstep.
+func (rc *remoteCluster) nextRetryInterval(success bool) time.Duration {
+	if success {
+		rc.retryInterval = rc.mesh.conf.RemoteRetryIntervalStart
+		return rc.retryInterval
+	}
+
+	max := rc.mesh.conf.RemoteRetryIntervalMax
+	interval := rc.retryInterval
+	if interval > max {
+		interval = max
+	}
+	next := interval * 2
+	if next > max {
+		next = max
+	}
+	rc.retryInterval = next
+
+	jitter := time.Duration(rand.Int63n(int64(interval) / 5))
+	return interval + jitter
+}
+
+// validateBackend checks that configured, the local per-cluster config
+// file's backend: selection (BackendEtcd when empty), is one of the
+// transports the remote cluster actually advertises through its
+// published ClusterConfig.Backends, so that a mixed-mode mesh fails
+// loudly instead of silently connecting over a transport the remote end
+// doesn't vouch for. A remote cluster which predates the Backends field
+// (advertised is empty) cannot be validated against, so it is always
+// accepted, for compatibility.
+func validateBackend(configured string, advertised []string) error {
+	if configured == "" {
+		configured = internal.BackendEtcd
+	}
+	if len(advertised) == 0 {
+		return nil
+	}
+
+	for _, backend := range advertised {
+		if backend == configured {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("remote cluster does not advertise support for configured backend %q (advertises %v)", configured, advertised)
+}
+
+// clusterConfigPollInterval is how often restartRemoteConnection
+// re-checks the remote cluster's published ClusterConfig for an ID
+// change while otherwise staying connected, so that a healthy cluster's
+// node watch is not torn down and re-established on every retry
+// interval.
+const clusterConfigPollInterval = 5 * time.Second
+
+// restartRemoteConnection (re)reads the configuration file and
+// establishes a new connection through the appropriate RemoteBackend,
+// bounded by Config.RemoteConnectTimeout, then watches the nodes prefix
+// and negotiates the remote cluster's capabilities. If the cluster's ID
+// changed since the last successful connection, rc.ctx is replaced with
+// a fresh one first, so that watchers started under the old ID are torn
+// down before new ones are started under the new ID.
+//
+// Once connected, it blocks, keeping the connection and watch alive,
+// until ctx ends (the cluster's configuration file disappeared, its ID
+// changed, or the ClusterMesh is draining) instead of returning
+// immediately: reconnecting a healthy cluster on every
+// RemoteRetryIntervalStart tick would otherwise starve the config
+// watcher exactly as described in the original bug report.
+func (rc *remoteCluster) restartRemoteConnection(ctx context.Context) error {
+	start := time.Now()
+	connectDone := false
+	defer func() {
+		if !connectDone {
+			rc.mesh.conf.Metrics.ConnectDurationSeconds.WithLabelValues(rc.name).Observe(time.Since(start).Seconds())
+		}
+	}()
+
+	connectCtx, cancel := context.WithTimeout(ctx, rc.mesh.conf.RemoteConnectTimeout)
+	defer cancel()
+
+	raw, err := os.ReadFile(rc.path)
+	if err != nil {
+		return err
+	}
+
+	file, err := internal.ParseClusterConfigFile(raw)
+	if err != nil {
+		return err
+	}
+
+	backend, err := internal.ConnectBackend(connectCtx, rc.name, file)
+	if err != nil {
+		return err
+	}
+
+	rc.swapBackend(backend)
+
+	// Fetch the cluster configuration through the newly connected
+	// backend itself, rather than the local kvstore, so that ID
+	// reservation and capability negotiation work identically whether
+	// the remote cluster is reached via etcd or via the clustermesh
+	// API gRPC service.
+	config, err := cmutils.GetClusterConfig(connectCtx, rc.name, rc.currentBackend())
+	if err != nil {
+		return err
+	}
+	var syncedCanaries bool
+	if config != nil {
+		if err := validateBackend(file.Backend, config.Backends); err != nil {
+			return err
+		}
+		if rc.lastID != 0 && rc.lastID != config.ID {
+			// restartContext cancels the ctx this function was called
+			// with, so connectCtx (a child of it) is now dead too: it
+			// has to be recomputed from the fresh ctx, or every
+			// Synced() wait below would fail immediately against an
+			// already-cancelled deadline instead of the new one.
+			ctx = rc.restartContext(config.ID)
+			connectCtx, cancel = context.WithTimeout(ctx, rc.mesh.conf.RemoteConnectTimeout)
+			defer cancel()
+		}
+		if err := rc.mesh.usedIDs.reserve(rc.name, config.ID); err != nil {
+			return err
+		}
+		rc.lastID = config.ID
+		syncedCanaries = config.Capabilities.SyncedCanaries
+	}
+
+	watchOpts := internal.WatchOptions{RequireSyncedCanary: syncedCanaries}
+	watcher, err := rc.currentBackend().Watch(ctx, nodeStore.NodeStorePrefix, rc.mesh.conf.NodeKeyCreator, rc.currentObserver(), watchOpts)
+	if err != nil {
+		return err
+	}
+
+	if err := watcher.Synced(connectCtx); err != nil {
+		return err
+	}
+
+	connectDone = true
+	rc.mesh.conf.Metrics.ConnectDurationSeconds.WithLabelValues(rc.name).Observe(time.Since(start).Seconds())
+
+	rc.readyMu.Lock()
+	rc.ready = true
+	rc.readyMu.Unlock()
+
+	defer func() {
+		rc.readyMu.Lock()
+		rc.ready = false
+		rc.readyMu.Unlock()
+	}()
+
+	return rc.stayConnected(ctx)
+}
+
+// stayConnected blocks, periodically re-checking the remote cluster's
+// published ClusterConfig for an ID change, until ctx ends. A detected
+// ID change replaces rc's context (tearing down the watchers started
+// under the old ID) and returns nil so the caller reconnects under the
+// new one; any other outcome simply keeps the existing connection and
+// watch running.
+func (rc *remoteCluster) stayConnected(ctx context.Context) error {
+	ticker := time.NewTicker(clusterConfigPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			config, err := cmutils.GetClusterConfig(ctx, rc.name, rc.currentBackend())
+			if err != nil {
+				log.WithError(err).WithField("cluster", rc.name).Warning("Unable to refresh remote cluster configuration")
+				continue
+			}
+			if config != nil && rc.lastID != 0 && config.ID != rc.lastID {
+				// restartContext sets rc.lastID to config.ID itself, so
+				// that the restartRemoteConnection call this triggers
+				// sees a lastID that already matches config.ID and
+				// doesn't mistake it for a second, still-pending ID
+				// change and restart the context a second time,
+				// abandoning the Watch it had just started.
+				rc.restartContext(config.ID)
+				return nil
+			}
+		}
+	}
+}
+
+// restartContext cancels rc's current watcher context and replaces it,
+// together with its trackingObserver, with fresh ones derived from the
+// ClusterMesh root context, and records newID as rc.lastID so that
+// whichever caller reconnects under the new context (restartRemoteConnection,
+// whether called directly or via stayConnected returning) never mistakes
+// the ID change it already started handling for a second, still-pending
+// one. It first replays OnDelete for every key the old observer had
+// reported, so that a cluster ID change never leaves downstream
+// consumers holding state attributed to the cluster's previous
+// incarnation. It returns the new context, for the caller to keep using
+// instead of whatever context it already held.
+func (rc *remoteCluster) restartContext(newID uint32) context.Context {
+	rc.ctxMu.Lock()
+	oldObserver := rc.observer
+	rc.cancel()
+	rc.ctx, rc.cancel = context.WithCancel(rc.mesh.ctx)
+	rc.observer = newTrackingObserver(rc.mesh.conf.NodeObserver)
+	ctx := rc.ctx
+	rc.ctxMu.Unlock()
+
+	rc.lastID = newID
+
+	oldObserver.drain()
+	return ctx
+}
+
+func (rc *remoteCluster) isReady() bool {
+	rc.readyMu.RLock()
+	defer rc.readyMu.RUnlock()
+	return rc.ready
+}
+
+// stop cancels the remote cluster's watchers and waits up to
+// Config.DrainTimeout (or until ctx is cancelled, whichever comes
+// first) for them to fully return.
+func (rc *remoteCluster) stop(ctx context.Context) {
+	rc.ctxMu.Lock()
+	cancel := rc.cancel
+	backend := rc.backend
+	rc.backend = nil
+	rc.ctxMu.Unlock()
+	cancel()
+
+	if backend != nil {
+		backend.Close()
+	}
+
+	timeout := time.NewTimer(rc.mesh.conf.DrainTimeout)
+	defer timeout.Stop()
+
+	select {
+	case <-rc.done:
+	case <-timeout.C:
+		log.WithField("cluster", rc.name).Warning("Timed out waiting for remote cluster to drain")
+	case <-ctx.Done():
+	}
+
+	// Replay OnDelete for every key still outstanding ourselves,
+	// rather than relying solely on run()'s own deferred drain: if
+	// run() already exited (e.g. it observed cancellation before we
+	// got here) its defer has already fired on whatever observer was
+	// current at that time, but rc.observer may have been swapped out
+	// since (a cluster ID change installs a fresh one) and would
+	// otherwise never be drained. drain is idempotent, so calling it
+	// again here is harmless.
+	rc.currentObserver().drain()
+}
+
+// trackingObserver wraps a store.Observer, remembering every key that
+// has been reported through OnUpdate and not yet reported deleted, so
+// that drain can replay OnDelete for all of them when a remote cluster
+// goes away, ensuring downstream consumers (ipcache, policy) never
+// retain stale state for a cluster that is no longer watched.
+type trackingObserver struct {
+	inner store.Observer
+
+	mu   lock.Mutex
+	seen map[string]store.NamedKey
+}
+
+func newTrackingObserver(inner store.Observer) *trackingObserver {
+	return &trackingObserver{
+		inner: inner,
+		seen:  make(map[string]store.NamedKey),
+	}
+}
+
+func (o *trackingObserver) OnUpdate(k store.Key) {
+	if named, ok := k.(store.NamedKey); ok {
+		o.mu.Lock()
+		o.seen[named.GetKeyName()] = named
+		o.mu.Unlock()
+	}
+	o.inner.OnUpdate(k)
+}
+
+func (o *trackingObserver) OnDelete(k store.NamedKey) {
+	o.mu.Lock()
+	delete(o.seen, k.GetKeyName())
+	o.mu.Unlock()
+	o.inner.OnDelete(k)
+}
+
+// drain replays OnDelete for every key still outstanding.
+func (o *trackingObserver) drain() {
+	o.mu.Lock()
+	keys := make([]store.NamedKey, 0, len(o.seen))
+	for _, k := range o.seen {
+		keys = append(keys, k)
+	}
+	o.seen = make(map[string]store.NamedKey)
+	o.mu.Unlock()
+
+	for _, k := range keys {
+		o.inner.OnDelete(k)
+	}
+}
@@ -0,0 +1,44 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package types
+
+// ClusterIDName bundles together the identity of the local cluster, as
+// configured by the user.
+type ClusterIDName struct {
+	// ClusterID is the unique identifier of the local cluster.
+	ClusterID uint32
+
+	// ClusterName is the unique name of the local cluster.
+	ClusterName string
+}
+
+// ClusterCapabilities advertises the set of optional behaviors that a
+// remote cluster supports, so that the local cluster can adapt how it
+// consumes the remote state accordingly.
+type ClusterCapabilities struct {
+	// SyncedCanaries indicates whether the remote cluster writes a
+	// canary key once the initial list of a given prefix has been
+	// fully synchronized, allowing watchers to detect when they have
+	// seen the complete initial snapshot.
+	SyncedCanaries bool `json:"syncedCanaries,omitempty"`
+}
+
+// CiliumClusterConfig is the cluster configuration as stored by a remote
+// cluster, and retrieved by every other cluster connecting to it. It is
+// used to negotiate the features supported by both ends.
+type CiliumClusterConfig struct {
+	// ID is the cluster ID of the cluster which owns this configuration.
+	ID uint32 `json:"id,omitempty"`
+
+	// Capabilities is the set of optional capabilities supported by the
+	// cluster which owns this configuration.
+	Capabilities ClusterCapabilities `json:"capabilities,omitempty"`
+
+	// Backends lists the clustermesh transports (e.g. "etcd", "grpc")
+	// that the cluster which owns this configuration is reachable
+	// through. A peer checks its own configured backend against this
+	// list and refuses to connect when that backend is not listed; no
+	// other backend is tried in its place. An empty list is not checked.
+	Backends []string `json:"backends,omitempty"`
+}
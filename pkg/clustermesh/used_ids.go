@@ -0,0 +1,119 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package clustermesh
+
+import (
+	"fmt"
+
+	"github.com/cilium/cilium/pkg/lock"
+)
+
+// usedIDs tracks which cluster ID is currently reserved by which remote
+// cluster, so that a misconfigured mesh cannot assign the same ID to two
+// different clusters.
+//
+// usedClusterIDsMutex only ever guards the usedClusterIDs map itself
+// (membership checks, iteration, insertion, deletion). The actual
+// "release the old ID, reserve the new one" sequence performed on
+// reconnect is additionally serialized per cluster name through
+// perClusterLocks, so that a slow reconnect of cluster A never blocks a
+// concurrent reserve or release for an unrelated cluster B.
+type usedIDs struct {
+	usedClusterIDsMutex lock.RWMutex
+	usedClusterIDs      map[uint32]string
+
+	perClusterLocks perClusterLocks
+}
+
+func newUsedIDs() usedIDs {
+	return usedIDs{
+		usedClusterIDs:  make(map[uint32]string),
+		perClusterLocks: newPerClusterLocks(),
+	}
+}
+
+// reserve reserves id for the given cluster name, releasing whatever ID
+// was previously reserved by the same cluster. It fails, leaving the
+// existing reservations untouched, if id is already owned by a
+// different cluster. The release+check+reserve sequence is atomic with
+// respect to other callers operating on the same cluster name, but does
+// not block reserve/release calls for other clusters.
+func (u *usedIDs) reserve(name string, id uint32) error {
+	unlock := u.perClusterLocks.lock(name)
+	defer unlock()
+
+	u.usedClusterIDsMutex.Lock()
+	defer u.usedClusterIDsMutex.Unlock()
+
+	if owner, ok := u.usedClusterIDs[id]; ok && owner != name {
+		return fmt.Errorf("cluster ID %d is already reserved by cluster %q", id, owner)
+	}
+
+	for existing, owner := range u.usedClusterIDs {
+		if owner == name && existing != id {
+			delete(u.usedClusterIDs, existing)
+		}
+	}
+	u.usedClusterIDs[id] = name
+
+	return nil
+}
+
+// release releases whichever ID is currently reserved by the given
+// cluster name, if any.
+func (u *usedIDs) release(name string) {
+	unlock := u.perClusterLocks.lock(name)
+	defer unlock()
+
+	u.releaseLocked(name)
+}
+
+// releaseLocked removes whichever ID is currently reserved by name. The
+// caller must already hold the per-cluster lock for name.
+func (u *usedIDs) releaseLocked(name string) {
+	u.usedClusterIDsMutex.Lock()
+	defer u.usedClusterIDsMutex.Unlock()
+
+	for existing, owner := range u.usedClusterIDs {
+		if owner == name {
+			delete(u.usedClusterIDs, existing)
+			break
+		}
+	}
+}
+
+// perClusterLocks hands out one mutex per cluster name, created on first
+// use and kept around for the lifetime of the process. The map of
+// per-name locks itself is guarded by a short-lived RW lock, which is
+// only ever held for the time it takes to look up or create an entry,
+// never across the critical section the per-name lock protects.
+type perClusterLocks struct {
+	mu    lock.RWMutex
+	locks map[string]*lock.Mutex
+}
+
+func newPerClusterLocks() perClusterLocks {
+	return perClusterLocks{locks: make(map[string]*lock.Mutex)}
+}
+
+// lock acquires the mutex associated with name, creating it if
+// necessary, and returns a function that releases it.
+func (p *perClusterLocks) lock(name string) func() {
+	p.mu.RLock()
+	l, ok := p.locks[name]
+	p.mu.RUnlock()
+
+	if !ok {
+		p.mu.Lock()
+		l, ok = p.locks[name]
+		if !ok {
+			l = new(lock.Mutex)
+			p.locks[name] = l
+		}
+		p.mu.Unlock()
+	}
+
+	l.Lock()
+	return l.Unlock
+}
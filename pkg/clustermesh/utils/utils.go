@@ -0,0 +1,62 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+
+	cmtypes "github.com/cilium/cilium/pkg/clustermesh/types"
+	"github.com/cilium/cilium/pkg/kvstore"
+)
+
+// clusterConfigPrefix is the kvstore prefix under which the per-cluster
+// configuration is published by each cluster for its peers to discover.
+const clusterConfigPrefix = "cilium/cluster-config"
+
+// SetClusterConfig stores the given cluster configuration in the kvstore,
+// so that it can be retrieved by any other cluster connecting to this
+// one through the clustermesh.
+func SetClusterConfig(ctx context.Context, clusterName string, config *cmtypes.CiliumClusterConfig, backend kvstore.BackendOperations) error {
+	key := path.Join(clusterConfigPrefix, clusterName)
+
+	val, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	return backend.Update(ctx, key, val, true)
+}
+
+// Getter is the minimal read capability GetClusterConfig needs.
+// kvstore.BackendOperations and internal.RemoteBackend both satisfy it,
+// so the cluster configuration can be retrieved identically regardless
+// of which transport is used to reach the cluster that published it.
+type Getter interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// GetClusterConfig retrieves the cluster configuration published by
+// clusterName, if any. It returns a nil config without error if the
+// remote cluster has not published one, for compatibility with Cilium
+// versions which predate this feature.
+func GetClusterConfig(ctx context.Context, clusterName string, getter Getter) (*cmtypes.CiliumClusterConfig, error) {
+	key := path.Join(clusterConfigPrefix, clusterName)
+
+	val, err := getter.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if val == nil {
+		return nil, nil
+	}
+
+	var config cmtypes.CiliumClusterConfig
+	if err := json.Unmarshal(val, &config); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}